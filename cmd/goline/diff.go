@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// diff returns a GNU unified diff between the contents of aname/a and
+// bname/b. It follows cmd/gofmt's approach: shell out to the system
+// "diff" binary when one is available, and fall back to a small in-process
+// line diff on platforms that don't have one.
+func diff(aname string, a []byte, bname string, b []byte) ([]byte, error) {
+	if _, err := exec.LookPath("diff"); err == nil {
+		return externalDiff(aname, a, bname, b)
+	}
+
+	return unifiedDiff(aname, a, bname, b), nil
+}
+
+// externalDiff writes a and b to temp files and shells out to "diff -u",
+// rewriting the temp file names in its output back to aname/bname.
+func externalDiff(aname string, a []byte, bname string, b []byte) ([]byte, error) {
+	af, err := os.CreateTemp("", "goline")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file for diff: %s",
+			err.Error())
+	}
+	defer os.Remove(af.Name())
+	defer af.Close()
+
+	bf, err := os.CreateTemp("", "goline")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file for diff: %s",
+			err.Error())
+	}
+	defer os.Remove(bf.Name())
+	defer bf.Close()
+
+	if _, err := af.Write(a); err != nil {
+		return nil, fmt.Errorf("failed to write temp file for diff: %s",
+			err.Error())
+	}
+	if _, err := bf.Write(b); err != nil {
+		return nil, fmt.Errorf("failed to write temp file for diff: %s",
+			err.Error())
+	}
+
+	out, err := exec.Command("diff", "-u", af.Name(), bf.Name()).Output()
+	if err != nil {
+		// diff exits with status 1 when the files differ, which is not a
+		// real failure.
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, fmt.Errorf("failed to run diff: %s", err.Error())
+		}
+	}
+
+	out = bytes.ReplaceAll(out, []byte(af.Name()), []byte(aname))
+	out = bytes.ReplaceAll(out, []byte(bf.Name()), []byte(bname))
+
+	return out, nil
+}
+
+// unifiedDiff is a minimal pure-Go unified diff used as a fallback when the
+// system has no "diff" binary. Unlike GNU diff it emits a single hunk
+// spanning the whole file rather than splitting on runs of unchanged
+// context, which is still a valid (if less compact) unified diff.
+func unifiedDiff(aname string, a []byte, bname string, b []byte) []byte {
+	aLines := splitLines(a)
+	bLines := splitLines(b)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n", aname)
+	fmt.Fprintf(&out, "+++ %s\n", bname)
+	fmt.Fprintf(&out, "@@ -1,%d +1,%d @@\n", len(aLines), len(bLines))
+
+	for _, op := range lcsLines(aLines, bLines) {
+		fmt.Fprintf(&out, "%c%s", op.kind, op.line)
+	}
+
+	return out.Bytes()
+}
+
+// splitLines splits src into lines, keeping each line's trailing newline
+// so the diff output reproduces src exactly.
+func splitLines(src []byte) []string {
+	lines := strings.SplitAfter(string(src), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffOp is one line of a unifiedDiff: kept as context (' '), removed
+// from a ('-'), or added from b ('+').
+type diffOp struct {
+	kind rune
+	line string
+}
+
+// lcsLines diffs a against b line by line using the standard longest
+// common subsequence dynamic program. It favors simplicity over
+// performance on huge inputs, since it's only reached when the system has
+// no "diff" binary to shell out to.
+func lcsLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+
+	dp := make([][]int, n+1)
+	for i := range dp {
+		dp[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				dp[i][j] = dp[i+1][j+1] + 1
+			case dp[i+1][j] >= dp[i][j+1]:
+				dp[i][j] = dp[i+1][j]
+			default:
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case dp[i+1][j] >= dp[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+
+	return ops
+}
@@ -0,0 +1,198 @@
+/*
+usage: goline [-w] [-d] [-l] [-line] maxlen path ...
+
+maxlen is the max line length to enforce, path ... is
+a list of space seperated files to format.
+
+By default the wrapped source is printed to stdout. Outputs GNU diff
+compatable transforms on files to ensure their lines are no longer than
+the maxlen.
+
+Nothing is done in cases where lines cannot be made shorter than maxlen.
+
+Flags:
+
+	-w	write result to (source) file instead of stdout
+	-d	display diffs instead of rewriting files
+	-l	list files whose formatting differs from goline's
+	-line	emit //line directives so wrapped lines keep their original
+		line numbers
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Noah-Huppert/goline"
+)
+
+var (
+	write       = flag.Bool("w", false, "write result to (source) file instead of stdout")
+	doDiff      = flag.Bool("d", false, "display diffs instead of rewriting files")
+	list        = flag.Bool("l", false, "list files whose formatting differs from goline's")
+	keepLineNos = flag.Bool("line", false, "emit //line directives so wrapped lines keep their original line numbers")
+)
+
+// readBufferSize is the size of the buffer processFile reads a file
+// through, matching the buffer size goline.Format gives its own
+// bufio.Writer (see that doc comment for why).
+const readBufferSize = 1 << 20 // 1 MiB
+
+// handleErr prints and exits if there is an error
+func handleErr(err error, msg string, a ...interface{}) {
+	if err == nil {
+		return
+	}
+
+	log.Fatalf("%s: %s\n", fmt.Sprintf(msg, a...), err.Error())
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: goline [-w] [-d] [-l] [-line] maxlen path ...\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	maxlen, err := strconv.ParseUint(args[0], 10, 64)
+	handleErr(err, "failed to parse maxlen \"%s\" argument as int", args[0])
+
+	for _, path := range args[1:] {
+		err := processFile(path, maxlen)
+		handleErr(err, "failed to format file \"%s\"", path)
+	}
+}
+
+// processFile reads path, wraps lines past maxlen characters, and then,
+// depending on the -w/-d/-l flags, writes the result back to path, prints
+// a diff, lists path's name, or (the default) prints the wrapped source to
+// stdout.
+func processFile(path string, maxlen uint64) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %s", err.Error())
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %s", err.Error())
+	}
+
+	f, err := os.Open(absPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %s", err.Error())
+	}
+	defer f.Close()
+
+	src, err := io.ReadAll(bufio.NewReaderSize(f, readBufferSize))
+	if err != nil {
+		return fmt.Errorf("failed to read file contents: %s", err.Error())
+	}
+
+	opts := goline.Options{
+		MaxLen:            maxlen,
+		Filename:          path,
+		PreservePositions: *keepLineNos,
+	}
+
+	// The default mode has nothing to compare the result against, so it
+	// can stream straight to stdout via goline.Format instead of going
+	// through goline.Source, which (like the -w/-d/-l modes below
+	// genuinely need to) builds the whole wrapped result in memory.
+	if !*list && !*write && !*doDiff {
+		if err := goline.Format(os.Stdout, src, opts); err != nil {
+			return fmt.Errorf("failed to wrap source: %s", err.Error())
+		}
+		fmt.Println()
+
+		return nil
+	}
+
+	out, err := goline.Source(src, opts)
+	if err != nil {
+		return fmt.Errorf("failed to wrap source: %s", err.Error())
+	}
+
+	changed := !bytes.Equal(src, out)
+
+	switch {
+	case *list:
+		if changed {
+			fmt.Println(path)
+		}
+
+	case *write:
+		if changed {
+			if err := writeFileAtomic(absPath, out, info.Mode()); err != nil {
+				return fmt.Errorf("failed to write file: %s", err.Error())
+			}
+		}
+
+	case *doDiff:
+		if changed {
+			d, err := diff(path, src, path, out)
+			if err != nil {
+				return fmt.Errorf("failed to compute diff: %s", err.Error())
+			}
+
+			os.Stdout.Write(d)
+		}
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes data to a new temp file in the same directory as
+// path, then renames it over path, so that a crash (or another process
+// reading path concurrently) never observes a truncated file. mode is
+// applied to the temp file before the rename so that path's permissions
+// are preserved.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".goline-tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %s", err.Error())
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write temp file: %s", err.Error())
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to close temp file: %s", err.Error())
+	}
+
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to set temp file mode: %s", err.Error())
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename temp file over \"%s\": %s",
+			path, err.Error())
+	}
+
+	return nil
+}
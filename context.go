@@ -0,0 +1,219 @@
+package goline
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+)
+
+// contextIndex maps source byte offsets to the syntactic construct they sit
+// inside, built once per file by walking its AST. A nil *contextIndex
+// (when src couldn't be parsed in any shape Source accepts) means no
+// context is available and every token gets ConstructNone.
+//
+// Offsets, rather than token.Pos, are what intervals are keyed on: a
+// fragment built by buildFragmentContextIndex is parsed from src wrapped
+// in a throwaway package/func (see parseFragment), in its own FileSet, so
+// its node positions don't share a coordinate space with the FileSet
+// wrapSourceTo scans src with. Offsets are the common ground both can be
+// translated into.
+type contextIndex struct {
+	intervals []contextInterval
+
+	// compositeLits maps a composite literal's closing "}" offset to its
+	// opening "{" offset, so wrapSource can recognize which closing
+	// braces may need a trailing comma inserted ahead of them.
+	compositeLits map[int]int
+}
+
+// contextInterval records that every offset in [start, end) belongs to
+// construct, with alignCol as its alignment column (see Context.AlignCol).
+type contextInterval struct {
+	start, end int
+	construct  ConstructKind
+	alignCol   int
+}
+
+// buildContextIndex walks f, recording the ranges of every construct
+// wrapSource gives special wrap handling to. f's positions are resolved
+// through fset and then shifted left by prefixLen, so the index ends up
+// keyed on offsets into the original, unwrapped source: a direct parse of
+// src has nothing to shift (prefixLen is 0), while a fragment parsed via
+// one of parseFragment's wrapper shapes has the wrapper's length to strip
+// back off (see buildFragmentContextIndex).
+func buildContextIndex(fset *token.FileSet, f *ast.File, prefixLen int) *contextIndex {
+	idx := &contextIndex{compositeLits: map[int]int{}}
+
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset - prefixLen
+	}
+	col := func(pos token.Pos) int {
+		return fset.Position(pos).Column
+	}
+
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.CallExpr:
+			idx.intervals = append(idx.intervals, contextInterval{
+				start: offset(node.Lparen) + 1, end: offset(node.Rparen),
+				construct: ConstructCommaList, alignCol: col(node.Lparen),
+			})
+
+		case *ast.FieldList:
+			if node.Opening.IsValid() {
+				idx.intervals = append(idx.intervals, contextInterval{
+					start: offset(node.Opening) + 1, end: offset(node.Closing),
+					construct: ConstructCommaList, alignCol: col(node.Opening),
+				})
+			}
+
+		case *ast.CompositeLit:
+			lbrace, rbrace := offset(node.Lbrace), offset(node.Rbrace)
+			idx.intervals = append(idx.intervals, contextInterval{
+				start: lbrace + 1, end: rbrace,
+				construct: ConstructCompositeLit, alignCol: col(node.Lbrace),
+			})
+			idx.compositeLits[rbrace] = lbrace
+
+		case *ast.BinaryExpr:
+			idx.intervals = append(idx.intervals, contextInterval{
+				start: offset(node.X.End()), end: offset(node.Y.Pos()),
+				construct: ConstructBinaryExpr,
+			})
+		}
+
+		return true
+	})
+
+	return idx
+}
+
+// fragmentWrappers are the two wrapper shapes, besides a complete file,
+// that parseFragment accepts: a declaration list and a statement list, as
+// either would be lifted from an editor selection. Each prefix ends in a
+// newline (unlike parseFragment's own, which doesn't need one) so that
+// src's first line still starts at column 1 -- buildContextIndex's
+// alignment columns would otherwise be thrown off by the prefix sharing
+// src's first line.
+var fragmentWrappers = [...]struct {
+	prefix, suffix string
+}{
+	{prefix: "package p;\n"},
+	{prefix: "package p;\nfunc _() {\n", suffix: "\n}"},
+}
+
+// buildFragmentContextIndex builds a *contextIndex for src when it doesn't
+// parse as a complete file on its own -- the fragment cases parseFragment
+// exists to accept. It retries src wrapped in each of fragmentWrappers'
+// shapes, in a throwaway FileSet, and shifts every position the
+// successful parse's AST recorded back by that wrapper's prefix length so
+// the result lines up with src's own offsets exactly like a direct
+// parse's would.
+func buildFragmentContextIndex(src []byte) *contextIndex {
+	for _, w := range fragmentWrappers {
+		wrapped := w.prefix + string(src) + w.suffix
+
+		fset := token.NewFileSet()
+		astFile, err := parser.ParseFile(fset, "", wrapped, 0)
+		if err != nil {
+			continue
+		}
+
+		return buildContextIndex(fset, astFile, len(w.prefix))
+	}
+
+	return nil
+}
+
+// lookup returns the construct and alignment column of the smallest
+// interval containing offset, or ConstructNone if none does.
+func (idx *contextIndex) lookup(offset int) (ConstructKind, int) {
+	if idx == nil {
+		return ConstructNone, 0
+	}
+
+	found := false
+	var best contextInterval
+	var bestSpan int
+
+	for _, iv := range idx.intervals {
+		if offset < iv.start || offset >= iv.end {
+			continue
+		}
+
+		span := iv.end - iv.start
+		if !found || span < bestSpan {
+			best, bestSpan, found = iv, span, true
+		}
+	}
+
+	if !found {
+		return ConstructNone, 0
+	}
+
+	return best.construct, best.alignCol
+}
+
+// compositeLitAt returns the opening "{" offset of the innermost composite
+// literal containing offset, if any.
+func (idx *contextIndex) compositeLitAt(offset int) (int, bool) {
+	if idx == nil {
+		return 0, false
+	}
+
+	found := false
+	var best contextInterval
+	var bestSpan int
+
+	for _, iv := range idx.intervals {
+		if iv.construct != ConstructCompositeLit {
+			continue
+		}
+		if offset < iv.start || offset >= iv.end {
+			continue
+		}
+
+		span := iv.end - iv.start
+		if !found || span < bestSpan {
+			best, bestSpan, found = iv, span, true
+		}
+	}
+
+	if !found {
+		return 0, false
+	}
+
+	return best.start - 1, true
+}
+
+// compositeLitSpan returns the alignment column and raw body width (the
+// number of source bytes between its "{" and "}") of the composite
+// literal opening at lbrace, if there is one. wrapSourceTo uses this as
+// soon as it reaches the "{" to decide whether the literal will need to
+// wrap at all -- and if so, locks it to one element per line from the
+// very first element, matching gofmt instead of packing as many elements
+// per line as fit.
+func (idx *contextIndex) compositeLitSpan(lbrace int) (alignCol, width int, ok bool) {
+	if idx == nil {
+		return 0, 0, false
+	}
+
+	for _, iv := range idx.intervals {
+		if iv.construct == ConstructCompositeLit && iv.start-1 == lbrace {
+			return iv.alignCol, iv.end - iv.start, true
+		}
+	}
+
+	return 0, 0, false
+}
+
+// compositeLitOpen reports whether rbrace (a closing "}"'s offset) closes
+// a composite literal, returning that literal's opening "{" offset.
+func (idx *contextIndex) compositeLitOpen(rbrace int) (int, bool) {
+	if idx == nil {
+		return 0, false
+	}
+
+	lbrace, ok := idx.compositeLits[rbrace]
+	return lbrace, ok
+}
@@ -0,0 +1,741 @@
+// Package goline wraps Go source lines that are longer than a configured
+// maximum, without ever splitting a token (a string, a rune literal, an
+// identifier, ...) in half. It is meant to be embedded the same way
+// go/format is: editors, linters, and pre-commit hooks can call Source or
+// Node directly instead of shelling out to a CLI.
+package goline
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/scanner"
+	"go/token"
+	"io"
+	"strings"
+)
+
+// IndentStyle selects between tab and space indentation for the printer
+// used by Node.
+type IndentStyle int
+
+const (
+	// IndentTabs indents with tabs, go/printer's default.
+	IndentTabs IndentStyle = iota
+
+	// IndentSpaces indents with spaces instead of tabs.
+	IndentSpaces
+)
+
+// Options configures how Source and Node wrap lines.
+type Options struct {
+	// MaxLen is the maximum number of characters a line can be
+	MaxLen uint64
+
+	// TabWidth is the number of columns a tab is assumed to occupy.
+	// Defaults to 8, go/printer's own default, when left zero.
+	TabWidth int
+
+	// IndentStyle selects how the printer run by Node indents output.
+	// It has no effect on Source, which never re-indents lines that
+	// aren't being wrapped.
+	IndentStyle IndentStyle
+
+	// Rewrite, if set, is applied to the parsed node before Node prints
+	// it. This mirrors the rewrite hook go/format and gofmt's -r flag
+	// provide.
+	Rewrite func(*token.FileSet, ast.Node) ast.Node
+
+	// Filename is the path src was read from. It is only used to label
+	// parse errors and, when PreservePositions is set, the //line
+	// directives Source emits.
+	Filename string
+
+	// PreservePositions causes Source to emit a "//line filename:N"
+	// directive ahead of any line whose position a wrap has shifted, so
+	// that a compiler reparsing the wrapped output recovers the
+	// original source's line numbers for stack traces, go vet, coverage
+	// data, and debugger breakpoints.
+	PreservePositions bool
+
+	// WrapStrategies overrides the built-in WrapStrategy used for one or
+	// more ConstructKinds, letting callers customize (or replace
+	// entirely) how a particular kind of syntax wraps. Constructs left
+	// unset keep their built-in strategy.
+	WrapStrategies map[ConstructKind]WrapStrategy
+}
+
+// tabWidth returns o.TabWidth, defaulting to 8 when unset.
+func (o Options) tabWidth() int {
+	if o.TabWidth == 0 {
+		return 8
+	}
+
+	return o.TabWidth
+}
+
+// Source wraps the Go source src so that no line is longer than
+// opts.MaxLen, returning the wrapped source. src need not be a full Go
+// file: following the technique go/format.Source uses to accept partial
+// input, src is also accepted if it parses as a declaration list or a
+// statement list once wrapped in a throwaway package/function (see
+// parseFragment).
+func Source(src []byte, opts Options) ([]byte, error) {
+	if err := parseFragment(src); err != nil {
+		return nil, err
+	}
+
+	return wrapSource(src, opts)
+}
+
+// parseFragment reports whether src is valid Go as a complete file, a
+// declaration list, or a statement list -- the same three shapes
+// go/format.Source accepts, which is what lets editor plugins run just
+// the user's selection through goline instead of the whole file.
+//
+// Unlike go/format, goline never reprints the parsed AST: it tokenizes and
+// wraps the original bytes directly (see wrapSource), so there's no
+// wrapped source to strip back off (go/format's sourceAdj) and no extra
+// indent level introduced by the wrapper to compensate for (go/format's
+// indentAdj). The parse here exists only to reject input that isn't valid
+// Go in any of the three shapes.
+func parseFragment(src []byte) error {
+	fset := token.NewFileSet()
+
+	if _, err := parser.ParseFile(fset, "", src, parser.ParseComments); err == nil {
+		return nil
+	}
+
+	if _, err := parser.ParseFile(fset, "", "package p;"+string(src), 0); err == nil {
+		return nil
+	}
+
+	if _, err := parser.ParseFile(fset, "", "package p;func _(){"+string(src)+"\n}", 0); err == nil {
+		return nil
+	}
+
+	return fmt.Errorf("not a valid Go file, declaration list, or statement list")
+}
+
+// streamBufferSize is the size Format gives its bufio.Writer, chosen to
+// match the buffer size golang.org/x/tools/imports uses to raise
+// bufio.Scanner's default token limit, so an unusually long generated line
+// never forces a flush mid-token.
+const streamBufferSize = 1 << 20 // 1 MiB
+
+// Format wraps src the same way Source does, but writes the result
+// directly to dst as each output line is finished instead of building the
+// whole result in memory first. Source has to hold a second, complete copy
+// of the file for its return value; Format's maxlenWriter only ever holds
+// the line currently being written (plus the token about to be appended to
+// it) before flushing to dst, which is what keeps wrapping a large
+// generated file from doubling its memory footprint.
+//
+// src itself still has to be read into memory whole: wrapSource's
+// AST-based construct lookup (see context.go) and go/scanner both require
+// the complete file up front, so there's no way to tokenize src
+// incrementally. Format only removes the output side of that doubling; see
+// cmd/goline's processFile for how the CLI uses it.
+func Format(dst io.Writer, src []byte, opts Options) error {
+	if err := parseFragment(src); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriterSize(dst, streamBufferSize)
+	if err := wrapSourceTo(bw, src, opts); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// Node formats node (typically a *ast.File) using fset and go/printer,
+// applying opts.Rewrite first if set, then wraps the result so that no
+// line is longer than opts.MaxLen and writes it to dst.
+func Node(dst io.Writer, fset *token.FileSet, node ast.Node, opts Options) error {
+	if opts.Rewrite != nil {
+		node = opts.Rewrite(fset, node)
+	}
+
+	cfg := printer.Config{
+		Mode:     printer.UseSpaces | printer.TabIndent,
+		Tabwidth: opts.tabWidth(),
+	}
+	if opts.IndentStyle == IndentSpaces {
+		cfg.Mode = printer.UseSpaces
+	}
+
+	var printed bytes.Buffer
+	if err := cfg.Fprint(&printed, fset, node); err != nil {
+		return fmt.Errorf("failed to print node: %s", err.Error())
+	}
+
+	wrapped, err := wrapSource(printed.Bytes(), opts)
+	if err != nil {
+		return err
+	}
+
+	if _, err := dst.Write(wrapped); err != nil {
+		return fmt.Errorf("failed to write wrapped output: %s", err.Error())
+	}
+
+	return nil
+}
+
+// indentParams records the number of spaces and tabs which make up a
+// line's indentation. If both are specified it is assumed that tabs came
+// before spaces.
+type indentParams struct {
+	// spaces is the number of spaces
+	spaces uint
+
+	// tabs is the number of tabs
+	tabs uint
+}
+
+// bytes returns a byte array representing the spacing
+func (p indentParams) bytes() []byte {
+	o := []byte{}
+
+	for _, v := range p.tabsBytes() {
+		o = append(o, v)
+	}
+
+	for _, v := range p.spacesBytes() {
+		o = append(o, v)
+	}
+
+	return o
+}
+
+// spacesBytes returns a byte array full of spaces of size .spaces
+func (p indentParams) spacesBytes() []byte {
+	o := []byte{}
+	for i := uint(0); i < p.spaces; i++ {
+		o = append(o, byte(' '))
+	}
+	return o
+}
+
+// tabsBytes returns a byte array full of tabs of size .tabs
+func (p indentParams) tabsBytes() []byte {
+	o := []byte{}
+	for i := uint(0); i < p.tabs; i++ {
+		o = append(o, byte('\t'))
+	}
+	return o
+}
+
+// firstLineIndent returns the indentParams of src's first non-blank line.
+// wrapSource seeds its running indent with this instead of starting at
+// column 0, so that a wrapped continuation line on a partial snippet's
+// first line aligns with the indentation of the code the snippet was
+// lifted from, rather than the snippet's own (unindented) start.
+func firstLineIndent(src []byte) indentParams {
+	for _, line := range bytes.Split(src, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " \t")
+		if len(trimmed) == 0 {
+			continue
+		}
+
+		ws := line[:len(line)-len(trimmed)]
+
+		var p indentParams
+		for _, b := range ws {
+			switch b {
+			case '\t':
+				p.tabs++
+			case ' ':
+				p.spaces++
+			}
+		}
+		return p
+	}
+
+	return indentParams{}
+}
+
+// leadingIndent computes the indentParams of the line the next token
+// starts on, by looking at the whitespace following the final newline in
+// gap (the raw source bytes between the previous token and the next one).
+func leadingIndent(gap []byte) indentParams {
+	i := bytes.LastIndexByte(gap, '\n')
+	ws := gap[i+1:]
+
+	var p indentParams
+	for _, b := range ws {
+		switch b {
+		case '\t':
+			p.tabs++
+		case ' ':
+			p.spaces++
+		default:
+			return p
+		}
+	}
+
+	return p
+}
+
+// canWrapAfter reports whether a line break may be inserted immediately
+// after a token of this kind without letting Go's automatic semicolon
+// insertion rule corrupt the statement: after a comma in a call or
+// parameter list, or after a binary operator or the "." of a selector
+// expression. Identifiers, literals, and closing brackets are excluded on
+// purpose -- ASI terminates a statement right there, which is exactly the
+// line ending a wrap must never produce.
+func canWrapAfter(tok token.Token) bool {
+	switch tok {
+	case token.COMMA, token.PERIOD:
+		return true
+	case token.LPAREN, token.RPAREN, token.LBRACK, token.RBRACK,
+		token.LBRACE, token.RBRACE, token.SEMICOLON, token.COLON,
+		token.INC, token.DEC:
+		return false
+	}
+
+	return tok.IsOperator()
+}
+
+// lineWriter is the subset of *bytes.Buffer and *bufio.Writer a
+// maxlenWriter needs. Source and Node give it a *bytes.Buffer, since they
+// have to return the whole wrapped result anyway; Format gives it a
+// *bufio.Writer so output can be flushed to the real destination one line
+// at a time instead of accumulating in memory.
+type lineWriter interface {
+	io.Writer
+	io.StringWriter
+}
+
+// maxlenWriter writes tokens to an output buffer, inserting a line break
+// and re-indenting whenever the current line would otherwise grow past
+// maxlen. It only ever breaks at the gaps between tokens, so a token's
+// text is always written whole.
+type maxlenWriter struct {
+	// maxlen is the maximum number of characters a line can be
+	maxlen uint64
+
+	// out is the buffer to which output is written
+	out lineWriter
+
+	// flusher is out's Flush method, when out has one (ie. a
+	// *bufio.Writer, as Format uses). Whenever a newline is written,
+	// flushing immediately afterwards bounds out's own internal
+	// buffering to one completed line, regardless of how large a buffer
+	// it was constructed with. It's nil for the *bytes.Buffer Source and
+	// Node use, which has nothing to flush.
+	flusher interface{ Flush() error }
+
+	// lineLen is the length of the line currently being written to out
+	lineLen int
+
+	// line is the 1-indexed number of the output line currently being
+	// written to out. Unlike the original source's line numbers, this
+	// counts every newline actually written, including ones a wrap
+	// inserted, so it drifts away from the source's line numbers as
+	// soon as the first wrap happens. PreservePositions mode uses this
+	// drift to know when a //line directive is needed.
+	line int
+}
+
+// newMaxlenWriter creates a new maxlenWriter
+func newMaxlenWriter(maxlen uint64, out lineWriter) *maxlenWriter {
+	w := &maxlenWriter{
+		maxlen: maxlen,
+		out:    out,
+		line:   1,
+	}
+	w.flusher, _ = out.(interface{ Flush() error })
+
+	return w
+}
+
+// flushLine flushes out, if it has a Flush method, after a newline has just
+// been written to it.
+func (w *maxlenWriter) flushLine() error {
+	if w.flusher == nil {
+		return nil
+	}
+
+	if err := w.flusher.Flush(); err != nil {
+		return fmt.Errorf("failed to flush output line: %s", err.Error())
+	}
+
+	return nil
+}
+
+// WriteGap writes the raw source bytes between two tokens (whitespace and
+// any blank lines). If gap contains a newline the line length counter is
+// reset to the length of the text following the final newline.
+func (w *maxlenWriter) WriteGap(gap []byte) error {
+	if _, err := w.out.Write(gap); err != nil {
+		return fmt.Errorf("failed to write gap: %s", err.Error())
+	}
+
+	n := bytes.Count(gap, []byte("\n"))
+	w.line += n
+
+	if i := bytes.LastIndexByte(gap, '\n'); i >= 0 {
+		w.lineLen = len(gap) - i - 1
+	} else {
+		w.lineLen += len(gap)
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	return w.flushLine()
+}
+
+// WriteSegment writes one Line returned by a WrapStrategy to out: if
+// l.NewLine is set, a line break is written first. l.Text may itself
+// contain embedded newlines (eg. a reflowed block comment, or a raw
+// string literal), in which case the line length counter is reset the
+// same way WriteGap resets it.
+func (w *maxlenWriter) WriteSegment(l Line) error {
+	wroteNewline := l.NewLine
+
+	if l.NewLine {
+		if _, err := w.out.WriteString("\n"); err != nil {
+			return fmt.Errorf("failed to write line wrap: %s", err.Error())
+		}
+		w.line++
+		w.lineLen = 0
+	}
+
+	if _, err := w.out.WriteString(l.Text); err != nil {
+		return fmt.Errorf("failed to write %q: %s", l.Text, err.Error())
+	}
+
+	if n := strings.Count(l.Text, "\n"); n > 0 {
+		w.line += n
+		wroteNewline = true
+	}
+
+	if i := strings.LastIndexByte(l.Text, '\n'); i >= 0 {
+		w.lineLen = len(l.Text) - i - 1
+	} else {
+		w.lineLen += len(l.Text)
+	}
+
+	if !wroteNewline {
+		return nil
+	}
+
+	return w.flushLine()
+}
+
+// WriteRaw writes text to out as-is, with no wrap handling. It's used to
+// splice in bytes a WrapStrategy didn't produce, such as the trailing
+// comma inserted ahead of a composite literal's closing "}" once any of
+// its elements have wrapped.
+func (w *maxlenWriter) WriteRaw(text string) error {
+	return w.WriteSegment(Line{Text: text})
+}
+
+// WriteDirective writes a "//line filename:target" directive on its own
+// output line, then forces the running line counter to target -- that's
+// what the directive tells a compiler reparsing out to believe the next
+// line's number is.
+func (w *maxlenWriter) WriteDirective(filename string, target int) error {
+	if w.lineLen > 0 {
+		if _, err := w.out.WriteString("\n"); err != nil {
+			return fmt.Errorf("failed to end line before //line "+
+				"directive: %s", err.Error())
+		}
+	}
+
+	if _, err := fmt.Fprintf(w.out, "//line %s:%d\n", filename, target); err != nil {
+		return fmt.Errorf("failed to write //line directive: %s", err.Error())
+	}
+
+	w.line = target
+	w.lineLen = 0
+
+	return w.flushLine()
+}
+
+// wrapSource tokenizes src with go/scanner and writes it back out through a
+// maxlenWriter into a fresh in-memory buffer, returning the wrapped result.
+// It's the shared implementation behind Source and Node, which both need
+// to return the whole result rather than stream it.
+func wrapSource(src []byte, opts Options) ([]byte, error) {
+	var out bytes.Buffer
+	if err := wrapSourceTo(&out, src, opts); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// wrapSourceTo tokenizes src with go/scanner and writes it back out to out
+// through a maxlenWriter, inserting wraps wherever a line would otherwise
+// grow past opts.MaxLen. Which WrapStrategy decides a given token's wrap is
+// chosen from the syntactic construct (call args, a composite literal, a
+// binary expression chain, ...) it sits in, determined by walking src's
+// AST when it parses as one of the three shapes Source accepts.
+func wrapSourceTo(out lineWriter, src []byte, opts Options) error {
+	fset := token.NewFileSet()
+
+	var file *token.File
+	var ctxIndex *contextIndex
+
+	if astFile, err := parser.ParseFile(fset, opts.Filename, src, parser.ParseComments); err == nil {
+		file = fset.File(astFile.Pos())
+		ctxIndex = buildContextIndex(fset, astFile, 0)
+	} else {
+		// src doesn't parse as a complete file; it may still be one of
+		// the partial shapes Source accepts (see parseFragment), in
+		// which case the construct-aware strategies below can still
+		// apply once the fragment's own AST positions are recovered.
+		file = fset.AddFile(opts.Filename, fset.Base(), len(src))
+		ctxIndex = buildFragmentContextIndex(src)
+	}
+
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+
+	maxlenW := newMaxlenWriter(opts.MaxLen, out)
+
+	lineIndent := firstLineIndent(src)
+	prevEnd := 0
+	var prevTok token.Token
+
+	// forcedTrailingBreak is true when the previous token's strategy
+	// wrapped by breaking *after* that token (see binaryExprStrategy and
+	// defaultStrategy's operator/selector handling) rather than before
+	// this one. The source gap between the two tokens -- ordinarily just
+	// a single space -- is then stale: the forced break already put this
+	// token at the start of a fresh, indented line.
+	forcedTrailingBreak := false
+
+	// compositeLitWrapped tracks, for every composite literal currently
+	// open (keyed by its "{" position), whether any of its elements have
+	// wrapped so far -- that's what decides whether a trailing comma is
+	// inserted ahead of its closing "}".
+	compositeLitWrapped := map[int]bool{}
+
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+
+		// Semicolons inserted by the scanner's automatic semicolon
+		// insertion rule are reported with lit == "\n" and a position
+		// that doesn't correspond to real source bytes. The newline
+		// they represent is already part of the next gap, so skip
+		// them.
+		if tok == token.SEMICOLON && lit == "\n" {
+			continue
+		}
+
+		text := lit
+		if text == "" {
+			text = tok.String()
+		}
+
+		start := file.Offset(pos)
+		gap := src[prevEnd:start]
+		hasNewline := bytes.IndexByte(gap, '\n') >= 0
+
+		// Split the gap at its final newline so a //line directive, if
+		// one turns out to be needed below, can be written at column 0
+		// ahead of the token's own leading indentation rather than in
+		// the middle of it.
+		gapBeforeIndent, gapIndent := gap, []byte(nil)
+		if hasNewline {
+			i := bytes.LastIndexByte(gap, '\n')
+			gapBeforeIndent, gapIndent = gap[:i+1], gap[i+1:]
+		}
+
+		if forcedTrailingBreak && !hasNewline {
+			gapBeforeIndent = nil
+		}
+		forcedTrailingBreak = false
+
+		// A composite literal whose elements wrapped needs a trailing
+		// comma ahead of its closing "}" so gofmt keeps it multi-line.
+		// It has to be spliced in before the gap, since the comma
+		// belongs to the previous element, not to the brace's own line.
+		closingLbrace, closingWrappedLit := ctxIndex.compositeLitOpen(start)
+		closingWrappedLit = closingWrappedLit && compositeLitWrapped[closingLbrace]
+
+		if closingWrappedLit && prevTok != token.COMMA && prevTok != token.LBRACE {
+			if err := maxlenW.WriteRaw(","); err != nil {
+				return fmt.Errorf("failed to write trailing comma "+
+					"before composite literal's \"}\": %s", err.Error())
+			}
+		}
+
+		// A token on its own fresh source line always gets its leading
+		// gap written as-is. A token still on the previous line has its
+		// gap held back until the strategy below decides whether it's
+		// wrapping ahead of this token -- see the write below the switch.
+		if hasNewline {
+			if err := maxlenW.WriteGap(gapBeforeIndent); err != nil {
+				return fmt.Errorf("failed to write source gap before "+
+					"token %q: %s", text, err.Error())
+			}
+		}
+
+		// A previous wrap may have shifted every line after it by one or
+		// more lines. Resync by emitting a //line directive whenever a
+		// token starting a new source line no longer lands on the
+		// output line a compiler would expect.
+		if opts.PreservePositions && hasNewline {
+			inLine := fset.Position(pos).Line
+			if maxlenW.line != inLine {
+				err := maxlenW.WriteDirective(opts.Filename, inLine)
+				if err != nil {
+					return fmt.Errorf("failed to write //line "+
+						"directive before token %q: %s", text,
+						err.Error())
+				}
+			}
+		}
+
+		if len(gapIndent) > 0 {
+			if err := maxlenW.WriteGap(gapIndent); err != nil {
+				return fmt.Errorf("failed to write indentation "+
+					"before token %q: %s", text, err.Error())
+			}
+		}
+
+		if hasNewline {
+			lineIndent = leadingIndent(gap)
+		}
+
+		construct, alignCol := ctxIndex.lookup(start)
+
+		var strategy WrapStrategy
+		switch {
+		case tok == token.COMMENT && strings.HasPrefix(text, "/*"):
+			strategy = strategyFor(ConstructBlockComment, opts.WrapStrategies)
+		case tok == token.COMMENT:
+			strategy = strategyFor(ConstructLineComment, opts.WrapStrategies)
+		case hasNewline:
+			// The token already starts a fresh source line; there's
+			// nothing to wrap ahead of it.
+			strategy = nil
+		case closingWrappedLit:
+			// "}" itself sits outside the literal's own interval (see
+			// buildContextIndex), so construct above is ConstructNone and
+			// would otherwise fall to defaultStrategy, which sees a lone
+			// "}" always "fits" and leaves it packed onto the last
+			// element's line -- exactly what compositeLitCloseStrategy
+			// exists to override.
+			strategy = compositeLitCloseStrategy{}
+		default:
+			strategy = strategyFor(construct, opts.WrapStrategies)
+		}
+
+		var segments []Line
+		if strategy != nil {
+			// A composite literal that has already had one element wrap
+			// forces every remaining element onto its own line too (see
+			// compositeLitStrategy), regardless of whether this one
+			// would fit on the current line by itself.
+			compositeWrapped := false
+			if construct == ConstructCompositeLit {
+				if lbrace, ok := ctxIndex.compositeLitAt(start); ok {
+					compositeWrapped = compositeLitWrapped[lbrace]
+				}
+			}
+
+			// hasNewline is false here (the hasNewline case always takes
+			// strategy = nil above), so gapBeforeIndent hasn't been
+			// written to out yet; fold its length in so the strategy
+			// sees the line length its token will actually start from.
+			ctx := Context{
+				Construct:           construct,
+				Prev:                prevTok,
+				LineLen:             maxlenW.lineLen + len(gapBeforeIndent),
+				MaxLen:              opts.MaxLen,
+				Indent:              lineIndent,
+				AlignCol:            alignCol,
+				CompositeLitWrapped: compositeWrapped,
+			}
+			segments = strategy.Wrap(Token{Text: text, Kind: tok,
+				Pos: fset.Position(pos)}, ctx)
+		} else {
+			segments = []Line{{Text: text}}
+		}
+
+		// If this token opens a composite literal that can't fit on one
+		// line even on its own, lock it to one element per line starting
+		// from its very first element, rather than waiting to discover
+		// that only once some later element overflows mid-line. The fit
+		// check has to use the column "{" is actually about to render
+		// at -- which segments above has just decided, since an
+		// enclosing literal's own wrap may have already moved this "{"
+		// onto a fresh line -- not the AST's original-source column: for
+		// a literal nested inside another that's already wrapped, those
+		// can differ wildly, and using the stale source column would
+		// lock (or not) a nested literal based on where it used to sit
+		// rather than where it will actually render.
+		if tok == token.LBRACE {
+			if _, width, ok := ctxIndex.compositeLitSpan(start); ok {
+				pendingCol := maxlenW.lineLen + len(gapBeforeIndent)
+				if len(segments) > 0 && segments[0].NewLine {
+					pendingCol = len(segments[0].Text) - len(text)
+				}
+
+				if pendingCol+width+1 > int(opts.MaxLen) {
+					compositeLitWrapped[start] = true
+				}
+			}
+		}
+
+		// Only the operator/selector "wrap after" case ever returns more
+		// than one segment for a token (the token's own text, then a
+		// forced break+indent) -- see above.
+		forcedTrailingBreak = len(segments) > 1
+
+		// Write the held-back gap now, but only if the strategy left this
+		// token on the same line: if it wrapped ahead of the token
+		// instead, the wrap's own leading newline and indent already
+		// account for the gap, and writing both would leave a trailing
+		// space stranded right before the inserted line break.
+		if !hasNewline && !(len(segments) > 0 && segments[0].NewLine) {
+			if err := maxlenW.WriteGap(gapBeforeIndent); err != nil {
+				return fmt.Errorf("failed to write source gap before "+
+					"token %q: %s", text, err.Error())
+			}
+		}
+
+		if construct == ConstructCompositeLit {
+			for _, seg := range segments {
+				if seg.NewLine {
+					if lbrace, ok := ctxIndex.compositeLitAt(start); ok {
+						compositeLitWrapped[lbrace] = true
+					}
+				}
+			}
+		}
+
+		for _, seg := range segments {
+			if err := maxlenW.WriteSegment(seg); err != nil {
+				return fmt.Errorf("failed to write token %q: %s",
+					text, err.Error())
+			}
+		}
+
+		prevEnd = start + len(text)
+		prevTok = tok
+	}
+
+	// The scan loop above stops at token.EOF without ever consuming it, so
+	// any bytes between the last real token and the end of src -- almost
+	// always just the file's trailing newline -- are never written
+	// unless they're flushed here.
+	if err := maxlenW.WriteGap(src[prevEnd:]); err != nil {
+		return fmt.Errorf("failed to write trailing source bytes: %s", err.Error())
+	}
+
+	return nil
+}
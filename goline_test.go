@@ -0,0 +1,178 @@
+package goline
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"io"
+	"strings"
+	"testing"
+)
+
+// parses reports whether src parses as a complete Go file, failing t with
+// the parse error if it doesn't. It's used below to catch wraps that
+// produce syntactically invalid output.
+func parses(t *testing.T, src []byte) {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	if _, err := parser.ParseFile(fset, "", src, 0); err != nil {
+		t.Fatalf("wrapped output does not parse: %s\n---\n%s", err, src)
+	}
+}
+
+func TestSourceLeavesAlreadyShortLinesUnchanged(t *testing.T) {
+	src := []byte("package p\n\nfunc f() {\n\tfmt.Println(\"hi\")\n}\n")
+
+	out, err := Source(src, Options{MaxLen: 200})
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	if !bytes.Equal(out, src) {
+		t.Fatalf("Source changed already-short source:\nwant %q\ngot  %q", src, out)
+	}
+}
+
+func TestSourceWrapsCallArgsAfterComma(t *testing.T) {
+	src := []byte("package p\n\nfunc f() {\n\tfmt.Println(\"aaaa\", \"bbbb\", \"cccc\", \"dddd\")\n}\n")
+
+	out, err := Source(src, Options{MaxLen: 30})
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	parses(t, out)
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasSuffix(line, " ") {
+			t.Errorf("wrapped line has a trailing space: %q", line)
+		}
+	}
+
+	lines := strings.Split(string(out), "\n")
+	if len(lines) < 5 || !strings.Contains(lines[3], "\"bbbb\"") || !strings.Contains(lines[4], "\"cccc\"") {
+		t.Errorf("expected the call's arguments to wrap across lines, got:\n%s", out)
+	}
+}
+
+func TestSourceAcceptsPartialSnippetAndKeepsItsIndent(t *testing.T) {
+	// A statement lifted from inside a function body, indented the way an
+	// editor selection would be, with no enclosing package or func.
+	src := []byte("\tfmt.Println(\"aaaa\", \"bbbb\", \"cccc\", \"dddd\")\n")
+
+	out, err := Source(src, Options{MaxLen: 30})
+	if err != nil {
+		t.Fatalf("Source rejected a partial snippet: %s", err)
+	}
+
+	if !bytes.HasPrefix(out, []byte("\tfmt.Println(")) {
+		t.Fatalf("wrapped snippet lost its leading indent:\n%s", out)
+	}
+
+	// The call's arguments align to the column just past its opening
+	// "(", which is itself past the snippet's own leading tab, so every
+	// continuation line should be indented at least that far.
+	for _, line := range bytes.Split(out, []byte("\n"))[1:] {
+		if len(line) > 0 && !bytes.HasPrefix(line, []byte("\t")) && line[0] != ' ' {
+			t.Errorf("continuation line isn't indented to match the snippet: %q", line)
+		}
+	}
+}
+
+func TestSourceRejectsInvalidSnippet(t *testing.T) {
+	if _, err := Source([]byte("fmt.Println(\n"), Options{MaxLen: 80}); err == nil {
+		t.Fatal("Source accepted unparseable input")
+	}
+}
+
+func TestSourcePreservePositionsEmitsLineDirectives(t *testing.T) {
+	src := []byte("package p\n\nfunc f() {\n" +
+		"\tfmt.Println(\"aaaa\", \"bbbb\", \"cccc\", \"dddd\")\n" +
+		"\tx := 1\n" +
+		"\t_ = x\n" +
+		"}\n")
+
+	out, err := Source(src, Options{MaxLen: 30, Filename: "f.go", PreservePositions: true})
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	// Line 4 of src (the call) wraps onto an extra output line, shifting
+	// every following line down by one; line 5 (the "x := 1" assignment)
+	// is where a directive should appear to resync the compiler's line
+	// count back to the original source.
+	if !strings.Contains(string(out), "//line f.go:5\n") {
+		t.Fatalf("expected a //line directive resyncing to source line 5, got:\n%s", out)
+	}
+}
+
+func TestSourceWithoutPreservePositionsEmitsNoDirectives(t *testing.T) {
+	src := []byte("package p\n\nfunc f() {\n" +
+		"\tfmt.Println(\"aaaa\", \"bbbb\", \"cccc\", \"dddd\")\n" +
+		"}\n")
+
+	out, err := Source(src, Options{MaxLen: 30, Filename: "f.go"})
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	if strings.Contains(string(out), "//line") {
+		t.Fatalf("got a //line directive without PreservePositions set:\n%s", out)
+	}
+}
+
+func TestFormatMatchesSource(t *testing.T) {
+	src := []byte("package p\n\nfunc f() {\n\tfmt.Println(\"aaaa\", \"bbbb\", \"cccc\", \"dddd\")\n}\n")
+	opts := Options{MaxLen: 30}
+
+	wantSource, err := Source(src, opts)
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Format(&buf, src, opts); err != nil {
+		t.Fatalf("Format returned error: %s", err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), wantSource) {
+		t.Fatalf("Format and Source disagree:\nFormat: %q\nSource: %q", buf.Bytes(), wantSource)
+	}
+}
+
+// TestFormatStreamsToAPipe exercises Format against an io.Pipe rather than
+// an in-memory buffer like TestFormatMatchesSource does, confirming Format
+// works against dst implementations with no buffer of their own (a pipe's
+// Write blocks until something reads it) and not just io.Writers like
+// *bytes.Buffer that happily absorb an arbitrarily large single write.
+func TestFormatStreamsToAPipe(t *testing.T) {
+	src := []byte("package p\n\nfunc f() {\n\tfmt.Println(\"aaaa\", \"bbbb\", \"cccc\", \"dddd\")\n}\n")
+	opts := Options{MaxLen: 30}
+
+	wantSource, err := Source(src, opts)
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	pr, pw := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		err := Format(pw, src, opts)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	out, err := io.ReadAll(pr)
+	if err != nil {
+		t.Fatalf("reading Format's output: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Format returned error: %s", err)
+	}
+
+	if !bytes.Equal(out, wantSource) {
+		t.Fatalf("Format over a pipe disagrees with Source:\nFormat: %q\nSource: %q", out, wantSource)
+	}
+}
@@ -0,0 +1,359 @@
+package goline
+
+import (
+	"go/token"
+	"strings"
+)
+
+// ConstructKind classifies the syntactic construct a token belongs to, as
+// determined by walking the parsed AST (or, for comments, by the token's
+// own text). It's what lets wrapSource give call arguments, composite
+// literals, binary expressions, and comments their own wrapping behavior
+// instead of a single one-size-fits-all rule.
+type ConstructKind int
+
+const (
+	// ConstructNone is plain code with no special wrap handling.
+	ConstructNone ConstructKind = iota
+
+	// ConstructCommaList is a call's argument list or a parameter/result
+	// FieldList: a sequence of comma separated items that wraps by
+	// breaking after a comma and aligning continuations to the column
+	// just past the list's opening "(".
+	ConstructCommaList
+
+	// ConstructCompositeLit is a composite literal's element list. It
+	// wraps the same way ConstructCommaList does, but also gets a
+	// trailing comma inserted ahead of its closing "}" once any of its
+	// elements have wrapped, so gofmt keeps it multi-line.
+	ConstructCompositeLit
+
+	// ConstructBinaryExpr is a chain of binary operators, which wraps by
+	// breaking before the operator with a hanging indent.
+	ConstructBinaryExpr
+
+	// ConstructLineComment is a "//" comment, which wraps on word
+	// boundaries with each continuation line reprefixed with "//".
+	ConstructLineComment
+
+	// ConstructBlockComment is a "/* */" comment, which wraps on word
+	// boundaries, re-aligning a leading " * " if the comment uses one.
+	ConstructBlockComment
+)
+
+// Token is the lexical token a WrapStrategy is being asked to place, along
+// with its position in the original source.
+type Token struct {
+	// Text is the token's literal text, exactly as it appeared in src
+	// (or, for punctuation and keywords with no literal, its canonical
+	// spelling).
+	Text string
+
+	// Kind is the token's lexical kind.
+	Kind token.Token
+
+	// Pos is the token's position in the original source.
+	Pos token.Position
+}
+
+// Line is one output line segment a WrapStrategy wants written. NewLine
+// reports whether a line break must be written ahead of Text; Text may
+// itself still contain embedded newlines (eg. a reflowed block comment),
+// those are not wraps in this sense and don't need a separate Line.
+type Line struct {
+	// Text is the bytes to write for this segment.
+	Text string
+
+	// NewLine is true if a line break should be inserted before Text.
+	// The first Line a strategy returns for a token should always leave
+	// this false: the token's starting position is decided by the
+	// ordinary source gap between it and the previous token, not by the
+	// strategy.
+	NewLine bool
+}
+
+// Context describes the syntactic construct a token sits inside and the
+// writer's state immediately before it, giving a WrapStrategy everything
+// it needs to decide whether and how to wrap.
+type Context struct {
+	// Construct is the kind of syntax the token is part of.
+	Construct ConstructKind
+
+	// Prev is the token immediately before this one (token.ILLEGAL at
+	// the start of input).
+	Prev token.Token
+
+	// LineLen is the length of the output line so far, before this
+	// token is written.
+	LineLen int
+
+	// MaxLen is the configured maximum line length.
+	MaxLen uint64
+
+	// Indent is the indentation of the source line this token is on.
+	Indent indentParams
+
+	// AlignCol, for ConstructCommaList and ConstructCompositeLit, is the
+	// number of columns of the construct's opening "(" or "{", so a
+	// continuation line can be indented with that many spaces to align
+	// just past it. Zero means no alignment column is available.
+	AlignCol int
+
+	// CompositeLitWrapped is true, for ConstructCompositeLit, if an
+	// earlier element of the same composite literal has already wrapped
+	// onto its own line. compositeLitStrategy uses this to force every
+	// remaining element onto its own line too, rather than packing as
+	// many as fit per line the way ConstructCommaList does.
+	CompositeLitWrapped bool
+}
+
+// WrapStrategy decides how to place tok given its syntactic Context. It
+// returns the Line segments to write for tok: usually a single Line (the
+// token written as-is, or preceded by a wrap), but a comment strategy may
+// reflow a single token's text internally.
+type WrapStrategy interface {
+	Wrap(tok Token, ctx Context) []Line
+}
+
+// defaultStrategy reproduces goline's original, construct-agnostic
+// behavior: wrap immediately after a comma, or after a binary operator or
+// the "." of a selector, with a flat extra-tab hanging indent. It applies
+// to any code that isn't inside one of the special constructs below.
+type defaultStrategy struct{}
+
+func (defaultStrategy) Wrap(tok Token, ctx Context) []Line {
+	if ctx.LineLen+len(tok.Text) <= int(ctx.MaxLen) {
+		return []Line{{Text: tok.Text}}
+	}
+
+	if ctx.Prev == token.COMMA {
+		indent := ctx.Indent
+		indent.tabs++
+
+		return []Line{{Text: string(indent.bytes()) + tok.Text, NewLine: true}}
+	}
+
+	if !canWrapAfter(tok.Kind) {
+		return []Line{{Text: tok.Text}}
+	}
+
+	// Wrap after the operator/selector "." rather than before it: a line
+	// ending in the identifier, literal, or closing bracket that came
+	// before it would trigger Go's automatic semicolon insertion and
+	// silently split the statement, but a line ending in the operator
+	// itself never does.
+	indent := ctx.Indent
+	indent.tabs++
+
+	return []Line{
+		{Text: tok.Text},
+		{Text: string(indent.bytes()), NewLine: true},
+	}
+}
+
+// commaListAlignIndent returns the indentation bytes a comma-separated
+// list's continuation line should use: ctx.AlignCol spaces, aligning just
+// past the list's opening "(" or "{", when that column is known, otherwise
+// a flat extra-tab hanging indent.
+func commaListAlignIndent(ctx Context) []byte {
+	if ctx.AlignCol > 0 {
+		return []byte(strings.Repeat(" ", ctx.AlignCol))
+	}
+
+	indent := ctx.Indent
+	indent.tabs++
+	return indent.bytes()
+}
+
+// commaListStrategy wraps call argument lists and parameter/result field
+// lists by breaking after a comma, aligning continuations to the column
+// just past the list's opening "(" when one is known.
+type commaListStrategy struct{}
+
+func (commaListStrategy) Wrap(tok Token, ctx Context) []Line {
+	if ctx.LineLen+len(tok.Text) <= int(ctx.MaxLen) || ctx.Prev != token.COMMA {
+		return []Line{{Text: tok.Text}}
+	}
+
+	return []Line{{Text: string(commaListAlignIndent(ctx)) + tok.Text, NewLine: true}}
+}
+
+// compositeLitStrategy wraps a composite literal to one element per line,
+// not as many elements per line as fit the way commaListStrategy does:
+// wrapSourceTo pre-computes, as soon as it sees the literal's opening
+// "{", whether the whole thing can't fit on one line, and if so
+// CompositeLitWrapped is already true for the very first element -- so
+// it, like every element after it, starts on its own line. A single
+// element that's long enough to overflow on its own still forces the
+// ones after it, the same way it would if CompositeLitWrapped had been
+// set from the start.
+type compositeLitStrategy struct{}
+
+func (compositeLitStrategy) Wrap(tok Token, ctx Context) []Line {
+	if ctx.Prev != token.COMMA && ctx.Prev != token.LBRACE {
+		return []Line{{Text: tok.Text}}
+	}
+
+	fits := ctx.LineLen+len(tok.Text) <= int(ctx.MaxLen)
+	if fits && !ctx.CompositeLitWrapped {
+		return []Line{{Text: tok.Text}}
+	}
+
+	return []Line{{Text: string(commaListAlignIndent(ctx)) + tok.Text, NewLine: true}}
+}
+
+// compositeLitCloseStrategy places a wrapped composite literal's closing
+// "}" on its own line, indented back to the literal's own level rather
+// than its elements' one-deeper indent. wrapSourceTo selects it, in place
+// of compositeLitStrategy, for the "}" itself once any of the literal's
+// elements have wrapped: the trailing comma already spliced in ahead of
+// it (see wrapSourceTo) only keeps gofmt from collapsing the literal back
+// onto one line if that comma is also followed by a real line break --
+// otherwise the comma and "}" just end up packed together unwrapped, on
+// whatever line the last element landed on.
+type compositeLitCloseStrategy struct{}
+
+func (compositeLitCloseStrategy) Wrap(tok Token, ctx Context) []Line {
+	return []Line{{Text: string(ctx.Indent.bytes()) + tok.Text, NewLine: true}}
+}
+
+// binaryExprStrategy wraps a chain of binary operators by breaking after
+// the operator with a hanging indent, not before it: a line ending in the
+// left operand (an identifier, literal, or closing bracket, the
+// overwhelmingly common case) would trigger Go's automatic semicolon
+// insertion and silently split the expression into two statements. A line
+// ending in the operator itself never does, since operators aren't in
+// ASI's trigger set.
+type binaryExprStrategy struct{}
+
+func (binaryExprStrategy) Wrap(tok Token, ctx Context) []Line {
+	if ctx.LineLen+len(tok.Text) <= int(ctx.MaxLen) || !tok.Kind.IsOperator() {
+		return []Line{{Text: tok.Text}}
+	}
+
+	indent := ctx.Indent
+	indent.tabs++
+
+	return []Line{
+		{Text: tok.Text},
+		{Text: string(indent.bytes()), NewLine: true},
+	}
+}
+
+// isDirectiveComment reports whether text, a "//" line comment, is a Go
+// directive like "//go:generate ...", "//go:build ...", or "//line ..." --
+// recognized, per the toolchain's own convention, by having no space
+// immediately after the "//". Reflowing one like any other comment would
+// at best scramble a human-facing note; for a directive it's worse,
+// silently disabling the directive itself (the inserted space alone
+// breaks go:generate/go:build detection, even before the line gets split).
+func isDirectiveComment(text string) bool {
+	body := strings.TrimPrefix(text, "//")
+	return len(body) > 0 && body[0] != ' '
+}
+
+// lineCommentStrategy wraps a "//" comment on word boundaries once its
+// total length would overflow the line, reprefixing every continuation
+// line with "//" and the comment's own indentation. Line comments never
+// span more than one source line, so the whole token can always be safely
+// reflowed -- except a directive comment (see isDirectiveComment), which
+// is left untouched no matter how long it is: splitting or re-spacing it
+// would corrupt the directive instead of merely reformatting a comment.
+type lineCommentStrategy struct{}
+
+func (lineCommentStrategy) Wrap(tok Token, ctx Context) []Line {
+	if ctx.LineLen+len(tok.Text) <= int(ctx.MaxLen) || isDirectiveComment(tok.Text) {
+		return []Line{{Text: tok.Text}}
+	}
+
+	body := strings.TrimPrefix(tok.Text, "//")
+	body = strings.TrimPrefix(body, " ")
+
+	contPrefix := string(ctx.Indent.bytes()) + "// "
+	firstBudget := int(ctx.MaxLen) - ctx.LineLen
+
+	return []Line{{Text: wrapWords(body, "// ", contPrefix, int(ctx.MaxLen), firstBudget)}}
+}
+
+// blockCommentStrategy wraps a "/* */" comment on word boundaries once
+// its total length would overflow the line, re-aligning a leading " * "
+// if the comment already used one. Only single-line block comments are
+// reflowed this way: a block comment that already spans several source
+// lines keeps its existing layout (which may well be meaningful, eg. an
+// aligned table or a numbered list) rather than being torn up because its
+// total character count looks long.
+type blockCommentStrategy struct{}
+
+func (blockCommentStrategy) Wrap(tok Token, ctx Context) []Line {
+	if strings.Contains(tok.Text, "\n") ||
+		ctx.LineLen+len(tok.Text) <= int(ctx.MaxLen) {
+		return []Line{{Text: tok.Text}}
+	}
+
+	contPrefix := string(ctx.Indent.bytes()) + " * "
+	firstBudget := int(ctx.MaxLen) - ctx.LineLen
+
+	return []Line{{Text: wrapWords(tok.Text, "", contPrefix, int(ctx.MaxLen), firstBudget)}}
+}
+
+// wrapWords splits body into words and reassembles them into a single
+// string with embedded newlines, keeping every line within maxlen: the
+// first line gets firstPrefix and firstBudget characters to work with,
+// every following line gets contPrefix and the full maxlen.
+func wrapWords(body, firstPrefix, contPrefix string, maxlen, firstBudget int) string {
+	words := strings.Fields(body)
+	if len(words) == 0 {
+		return firstPrefix
+	}
+
+	prefix := firstPrefix
+	budget := firstBudget
+	cur := prefix
+	var out []string
+
+	for _, w := range words {
+		candidate := cur
+		if cur != prefix {
+			candidate += " " + w
+		} else {
+			candidate += w
+		}
+
+		if len(candidate) > budget && cur != prefix {
+			out = append(out, cur)
+			prefix = contPrefix
+			budget = maxlen
+			cur = prefix + w
+			continue
+		}
+
+		cur = candidate
+	}
+	out = append(out, cur)
+
+	return strings.Join(out, "\n")
+}
+
+// strategyFor returns the WrapStrategy to use for construct, preferring a
+// caller-registered override in overrides (see Options.WrapStrategies)
+// over the built-in default for that construct.
+func strategyFor(construct ConstructKind, overrides map[ConstructKind]WrapStrategy) WrapStrategy {
+	if s, ok := overrides[construct]; ok {
+		return s
+	}
+
+	switch construct {
+	case ConstructCommaList:
+		return commaListStrategy{}
+	case ConstructCompositeLit:
+		return compositeLitStrategy{}
+	case ConstructBinaryExpr:
+		return binaryExprStrategy{}
+	case ConstructLineComment:
+		return lineCommentStrategy{}
+	case ConstructBlockComment:
+		return blockCommentStrategy{}
+	default:
+		return defaultStrategy{}
+	}
+}
@@ -0,0 +1,174 @@
+package goline
+
+import (
+	"go/format"
+	"strings"
+	"testing"
+)
+
+func TestSourceWrapsCompositeLitOneElementPerLine(t *testing.T) {
+	src := []byte("package p\n\nfunc f() {\n\tx := []int{1, 2, 3, 4, 5, 6, 7, 8}\n\t_ = x\n}\n")
+
+	out, err := Source(src, Options{MaxLen: 30})
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	parses(t, out)
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasSuffix(line, " ") {
+			t.Errorf("wrapped line has a trailing space: %q", line)
+		}
+	}
+
+	for _, elem := range []string{"1,", "2,", "3,", "4,", "5,", "6,", "7,", "8,"} {
+		count := strings.Count(string(out), elem)
+		if count != 1 {
+			t.Errorf("expected exactly one occurrence of %q, got %d", elem, count)
+		}
+	}
+
+	lines := strings.Split(string(out), "\n")
+	elements := 0
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasSuffix(trimmed, ",") && !strings.Contains(trimmed, "{") {
+			elements++
+			if strings.Count(trimmed, ",") != 1 {
+				t.Errorf("expected one element per line, got %q", line)
+			}
+		}
+	}
+	if elements == 0 {
+		t.Errorf("expected the composite literal's elements to wrap onto their own lines, got:\n%s", out)
+	}
+
+	last := lines[len(lines)-1]
+	if last == "" {
+		last = lines[len(lines)-2]
+	}
+	if strings.TrimSpace(last) != "}" {
+		t.Errorf("expected the closing \"}\" on its own line, got %q", last)
+	}
+
+	// gofmt only keeps a composite literal multi-line if its trailing
+	// comma is followed by a real line break before "}": verify that by
+	// round-tripping through go/format, the same check gofmt itself
+	// uses, rather than just inspecting our own output.
+	formatted, err := format.Source(out)
+	if err != nil {
+		t.Fatalf("go/format.Source rejected the wrapped output: %s", err)
+	}
+	if strings.Count(string(formatted), "\n") < strings.Count(string(out), "\n")-2 {
+		t.Errorf("gofmt collapsed the wrapped literal back onto fewer lines:\n%s", formatted)
+	}
+}
+
+// TestSourceWrapsNestedCompositeLitsConsistently guards against locking a
+// nested composite literal to one-element-per-line based on the column
+// its "{" happened to sit at in the original source, rather than the
+// column it will actually render at once the outer literal has wrapped:
+// two sibling literals of identical width must get the same treatment.
+func TestSourceWrapsNestedCompositeLitsConsistently(t *testing.T) {
+	src := []byte("package p\n\nfunc f() {\n\tx := [][]int{{1, 2}, {3, 4}}\n\t_ = x\n}\n")
+
+	out, err := Source(src, Options{MaxLen: 25})
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	parses(t, out)
+
+	firstOnOneLine := strings.Contains(string(out), "{1, 2}")
+	secondOnOneLine := strings.Contains(string(out), "{3, 4}")
+	if firstOnOneLine != secondOnOneLine {
+		t.Errorf("sibling composite literals of the same width were wrapped inconsistently:\n%s", out)
+	}
+}
+
+func TestSourceWrapsBinaryExprAfterOperator(t *testing.T) {
+	src := []byte("package p\n\nfunc f() {\n\tif aLongCondition && anotherLongCondition && yetAnother {\n\t\t_ = 1\n\t}\n}\n")
+
+	out, err := Source(src, Options{MaxLen: 40})
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	parses(t, out)
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasSuffix(line, " ") {
+			t.Errorf("wrapped line has a trailing space: %q", line)
+		}
+
+		trimmed := strings.TrimRight(line, " \t")
+		if strings.Contains(trimmed, "&&") && !strings.HasSuffix(trimmed, "&&") &&
+			!strings.HasSuffix(trimmed, "{") {
+			t.Errorf("expected the wrap to leave \"&&\" at the end of the line, got %q", line)
+		}
+	}
+}
+
+func TestSourceWrapsLineComment(t *testing.T) {
+	src := []byte("package p\n\n// This is a long line comment that should wrap across several lines of output.\nfunc f() {}\n")
+
+	out, err := Source(src, Options{MaxLen: 30})
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	parses(t, out)
+
+	lines := strings.Split(string(out), "\n")
+	commentLines := 0
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "package p" || strings.HasPrefix(trimmed, "func") {
+			continue
+		}
+
+		commentLines++
+		if !strings.HasPrefix(trimmed, "//") {
+			t.Errorf("expected every comment continuation line to be reprefixed with //, got %q", line)
+		}
+	}
+
+	if commentLines < 2 {
+		t.Errorf("expected the comment to wrap across multiple lines, got:\n%s", out)
+	}
+}
+
+func TestSourceLeavesDirectiveCommentsUnwrapped(t *testing.T) {
+	directive := "//go:generate mockgen -source=verylongfilename.go -destination=mock_verylongfilename.go"
+	src := []byte("package p\n\n" + directive + "\nfunc f() {}\n")
+
+	out, err := Source(src, Options{MaxLen: 40})
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	parses(t, out)
+
+	if !strings.Contains(string(out), directive) {
+		t.Errorf("expected the directive comment to pass through unwrapped, got:\n%s", out)
+	}
+}
+
+func TestSourceNeverWrapsAfterIncDec(t *testing.T) {
+	src := []byte("package p\n\nfunc f() {\n\taVeryLongVariableNameThatIsQuiteLongIndeed++\n}\n")
+
+	out, err := Source(src, Options{MaxLen: 20})
+	if err != nil {
+		t.Fatalf("Source returned error: %s", err)
+	}
+
+	parses(t, out)
+
+	if strings.Contains(string(out), "++\n\t\t\n") || strings.Contains(string(out), "++\n\n") {
+		t.Errorf("expected no blank continuation line after \"++\", got:\n%s", out)
+	}
+	if !strings.Contains(string(out), "aVeryLongVariableNameThatIsQuiteLongIndeed++\n") {
+		t.Errorf("expected \"++\" to stay on the statement's own line, got:\n%s", out)
+	}
+}